@@ -3,15 +3,22 @@ package utils
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gravitational/teleport/lib/utils"
 	"github.com/gravitational/trace"
 	"github.com/julienschmidt/httprouter"
+	"github.com/quic-go/quic-go/http3"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
 )
 
 type HTTPConfig struct {
@@ -20,6 +27,135 @@ type HTTPConfig struct {
 	CertFile string `toml:"https-cert-file"`
 	Hostname string `toml:"host"`
 	Insecure bool
+
+	// ACMEEnabled turns on automatic certificate acquisition via ACME
+	// (e.g. Let's Encrypt) instead of using CertFile/KeyFile.
+	ACMEEnabled bool `toml:"acme-enabled"`
+	// ACMEEmail is the contact address registered with the ACME provider.
+	ACMEEmail string `toml:"acme-email"`
+	// ACMECacheDir is the directory where obtained certificates are cached.
+	ACMECacheDir string `toml:"acme-cache-dir"`
+	// ACMEHostnames restricts certificate issuance to this allowlist of
+	// hostnames, mirroring autocert.HostPolicy.
+	ACMEHostnames []string `toml:"acme-hostnames"`
+
+	// ClientCAFile is a PEM bundle of CA certificates used to verify client
+	// certificates presented by callers, enabling mutual TLS.
+	ClientCAFile string `toml:"https-client-ca-file"`
+	// ClientAuth selects how strictly client certificates are enforced:
+	// "require" (tls.RequireAndVerifyClientCert), "verify-if-given"
+	// (tls.VerifyClientCertIfGiven) or "none" (the default, no mTLS).
+	ClientAuth string `toml:"https-client-auth"`
+
+	// SNICerts lists additional named certificates served by SNI hostname,
+	// on top of the default CertFile/KeyFile pair.
+	SNICerts []SNICertConfig `toml:"sni_certs"`
+
+	// HTTP2 enables explicit HTTP/2 support over the TLS listener.
+	HTTP2 bool `toml:"http2"`
+	// HTTP3 additionally starts a HTTP/3 (QUIC) listener on the same port
+	// over UDP, sharing the same router.
+	HTTP3 bool `toml:"http3"`
+	// AltSvcHeader advertises the HTTP/3 endpoint to HTTP/1.1 and HTTP/2
+	// clients via the Alt-Svc response header.
+	AltSvcHeader bool `toml:"alt-svc-header"`
+
+	// AccessLog enables structured per-request access logging.
+	AccessLog bool `toml:"access-log"`
+	// MetricsEnabled enables Prometheus http_requests_total/
+	// http_request_duration_seconds metrics per route.
+	MetricsEnabled bool `toml:"metrics-enabled"`
+	// RateLimitRPS enables per-remote-IP token-bucket rate limiting at this
+	// many requests per second; zero disables rate limiting.
+	RateLimitRPS float64 `toml:"rate-limit-rps"`
+	// RateLimitBurst is the burst size allowed by the rate limiter.
+	RateLimitBurst int `toml:"rate-limit-burst"`
+}
+
+// SNICertConfig describes one additional certificate served only when the
+// TLS handshake's SNI hostname matches one of Names (which may include
+// leading-wildcard entries like "*.example.com").
+type SNICertConfig struct {
+	CertFile string   `toml:"cert_file"`
+	KeyFile  string   `toml:"key_file"`
+	Names    []string `toml:"names"`
+}
+
+// namedCertificateMap maps an SNI hostname (or wildcard pattern) to the
+// certificate that should be served for it.
+type namedCertificateMap map[string]*tls.Certificate
+
+// getCertificate returns the certificate registered for serverName, trying
+// an exact match first and then a leading-wildcard match, e.g. a lookup of
+// "foo.example.com" matches an entry registered under "*.example.com".
+func (m namedCertificateMap) getCertificate(serverName string) *tls.Certificate {
+	serverName = strings.ToLower(serverName)
+	if cert, ok := m[serverName]; ok {
+		return cert
+	}
+	if i := strings.IndexByte(serverName, '.'); i != -1 {
+		if cert, ok := m["*"+serverName[i:]]; ok {
+			return cert
+		}
+	}
+	return nil
+}
+
+// buildNamedCertificateMap loads every certificate in sniCerts and indexes
+// it under each of its configured names.
+func buildNamedCertificateMap(sniCerts []SNICertConfig) (namedCertificateMap, error) {
+	certMap := namedCertificateMap{}
+	for _, sniCert := range sniCerts {
+		cert, err := tls.LoadX509KeyPair(sniCert.CertFile, sniCert.KeyFile)
+		if err != nil {
+			return nil, trace.Wrap(err, "error loading SNI certificate %v", sniCert.CertFile)
+		}
+		for _, name := range sniCert.Names {
+			certMap[strings.ToLower(name)] = &cert
+		}
+	}
+	return certMap, nil
+}
+
+type peerIdentityContextKeyType struct{}
+
+var peerIdentityContextKey peerIdentityContextKeyType
+
+// PeerIdentity is the verified identity of a client that authenticated with
+// a client certificate over mTLS.
+type PeerIdentity struct {
+	// CommonName is the CN of the leaf client certificate.
+	CommonName string
+	// DNSNames and IPAddresses are the SANs of the leaf client certificate.
+	DNSNames    []string
+	IPAddresses []string
+}
+
+// PeerIdentityFromContext returns the verified client certificate identity
+// stashed in the context by the HTTP server, if the request was
+// authenticated via mTLS.
+func PeerIdentityFromContext(ctx context.Context) (PeerIdentity, bool) {
+	identity, ok := ctx.Value(peerIdentityContextKey).(PeerIdentity)
+	return identity, ok
+}
+
+// clientAuthType translates the HTTPConfig.ClientAuth setting into the
+// corresponding crypto/tls.ClientAuthType. An unrecognized value is a
+// misconfiguration rather than "no mTLS": since ClientAuth exists to
+// authorize inbound callers by client certificate, silently falling back
+// to tls.NoClientCert would leave the endpoint open without any
+// indication that the setting was ignored.
+func (c HTTPConfig) clientAuthType() (tls.ClientAuthType, error) {
+	switch c.ClientAuth {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	case "verify-if-given":
+		return tls.VerifyClientCertIfGiven, nil
+	default:
+		return tls.NoClientCert, trace.BadParameter("unrecognized ClientAuth value %q", c.ClientAuth)
+	}
 }
 
 // HTTP is a tiny wrapper around standard net/http.
@@ -30,8 +166,30 @@ type HTTP struct {
 	HTTPConfig
 	*httprouter.Router
 	server http.Server
+
+	// defaultCert holds the current *tls.Certificate loaded from
+	// CertFile/KeyFile. It is swapped atomically by the cert-reload
+	// goroutine so in-flight handshakes are never disrupted.
+	defaultCert atomic.Value
+
+	// certModTime is the newer of CertFile's and KeyFile's mtimes as last
+	// observed, used to detect on-disk changes.
+	certModTime time.Time
+
+	// middlewares are extra middlewares registered via Use, applied around
+	// the built-in ones enabled through HTTPConfig.
+	middlewares []Middleware
+
+	// acmeChallengeServer serves the ACME HTTP-01 challenge on :80 when
+	// ACMEEnabled is set. It is closed alongside the main server so it
+	// doesn't outlive the plugin.
+	acmeChallengeServer *http.Server
 }
 
+// certReloadCheckInterval is how often the cert-reload goroutine stats
+// CertFile/KeyFile for changes.
+const certReloadCheckInterval = 10 * time.Second
+
 type httpHandlerWrapper struct {
 	serve func(http.ResponseWriter, *http.Request)
 }
@@ -39,9 +197,9 @@ type httpHandlerWrapper struct {
 // NewHTTP creates a new HTTP wrapper
 func NewHTTP(config HTTPConfig) *HTTP {
 	return &HTTP{
-		config,
-		httprouter.New(),
-		http.Server{Addr: config.Listen},
+		HTTPConfig: config,
+		Router:     httprouter.New(),
+		server:     http.Server{Addr: config.Listen},
 	}
 }
 
@@ -50,6 +208,7 @@ func newHttpHandlerWrapper(baseCtx context.Context, handler http.Handler) *httpH
 		func(rw http.ResponseWriter, r *http.Request) {
 			ctx, cancel := context.WithCancel(baseCtx)
 			defer cancel()
+			ctx = withRouteNameBox(ctx)
 			go func() {
 				select {
 				case <-r.Context().Done():
@@ -57,11 +216,27 @@ func newHttpHandlerWrapper(baseCtx context.Context, handler http.Handler) *httpH
 				case <-ctx.Done():
 				}
 			}()
+			if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+				leaf := r.TLS.PeerCertificates[0]
+				ctx = context.WithValue(ctx, peerIdentityContextKey, PeerIdentity{
+					CommonName:  leaf.Subject.CommonName,
+					DNSNames:    leaf.DNSNames,
+					IPAddresses: ipAddressesToStrings(leaf.IPAddresses),
+				})
+			}
 			handler.ServeHTTP(rw, r.WithContext(ctx))
 		},
 	}
 }
 
+func ipAddressesToStrings(ips []net.IP) []string {
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = ip.String()
+	}
+	return addrs
+}
+
 func (h *httpHandlerWrapper) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	h.serve(rw, r)
 }
@@ -70,19 +245,83 @@ func (h *httpHandlerWrapper) ServeHTTP(rw http.ResponseWriter, r *http.Request)
 func (h *HTTP) ListenAndServe(ctx context.Context) error {
 	defer log.Info("HTTP server terminated")
 
-	h.server.Handler = newHttpHandlerWrapper(ctx, h.Router)
+	h.server.Handler = newHttpHandlerWrapper(ctx, h.wrapHandler(ctx, h.altSvcHandler(h.Router)))
 	go func() {
 		<-ctx.Done()
 		h.server.Close()
 	}()
 
 	var err error
-	if h.Insecure {
+	switch {
+	case h.Insecure:
 		log.Infof("Starting insecure HTTP server on %s", h.Listen)
 		err = h.server.ListenAndServe()
-	} else {
+	case h.ACMEEnabled:
+		// SNICerts serves a fixed set of static certificates via its own
+		// GetCertificate callback, which would either shadow or be shadowed
+		// by ACME's own dynamic GetCertificate depending on merge order.
+		// Rather than guess which certificate an operator wants for a given
+		// SNI name, refuse the combination outright.
+		if len(h.SNICerts) > 0 {
+			return trace.BadParameter("ACMEEnabled cannot be combined with SNICerts")
+		}
+		log.Infof("Starting secure HTTPS server on %s with ACME certificate provisioning", h.Listen)
+		manager := h.acmeManager()
+		tlsConfig := manager.TLSConfig()
+		if err := h.applyClientCA(tlsConfig); err != nil {
+			return trace.Wrap(err)
+		}
+		h.server.TLSConfig = tlsConfig
+		if err := h.configureHTTP2(); err != nil {
+			return trace.Wrap(err)
+		}
+
+		// The ACME HTTP-01 challenge must be served over plain HTTP on :80.
+		h.acmeChallengeServer = &http.Server{Addr: ":80", Handler: manager.HTTPHandler(nil)}
+		go func() {
+			<-ctx.Done()
+			h.acmeChallengeServer.Close()
+		}()
+		go func() {
+			if err := h.acmeChallengeServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.WithError(err).Error("ACME HTTP-01 challenge server failed")
+			}
+		}()
+
+		if h.HTTP3 {
+			go h.serveHTTP3(ctx, h.server.TLSConfig)
+		}
+
+		err = h.server.ListenAndServeTLS("", "")
+	default:
 		log.Infof("Starting secure HTTPS server on %s", h.Listen)
-		err = h.server.ListenAndServeTLS(h.CertFile, h.KeyFile)
+		if err := h.reloadDefaultCert(); err != nil {
+			return trace.Wrap(err)
+		}
+		tlsConfig, buildErr := h.buildTLSConfig()
+		if buildErr != nil {
+			return trace.Wrap(buildErr)
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		if tlsConfig.GetCertificate == nil {
+			tlsConfig.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+				return h.defaultCert.Load().(*tls.Certificate), nil
+			}
+		}
+		h.server.TLSConfig = tlsConfig
+		go h.watchCertReload(ctx)
+		if err := h.configureHTTP2(); err != nil {
+			return trace.Wrap(err)
+		}
+		if h.HTTP3 {
+			go h.serveHTTP3(ctx, tlsConfig)
+		}
+
+		// CertFile/KeyFile are deliberately omitted here: GetCertificate
+		// above serves the hot-reloadable certificate instead.
+		err = h.server.ListenAndServeTLS("", "")
 	}
 	if err == http.ErrServerClosed {
 		return nil
@@ -90,8 +329,185 @@ func (h *HTTP) ListenAndServe(ctx context.Context) error {
 	return trace.Wrap(err)
 }
 
+// configureHTTP2 explicitly enables HTTP/2 on h.server when HTTP2 is set,
+// rather than relying on Go's implicit negotiation.
+func (h *HTTP) configureHTTP2() error {
+	if !h.HTTP2 {
+		return nil
+	}
+	if err := http2.ConfigureServer(&h.server, &http2.Server{}); err != nil {
+		return trace.Wrap(err, "error configuring HTTP/2")
+	}
+	return nil
+}
+
+// serveHTTP3 runs an HTTP/3 (QUIC) listener on the same address as the main
+// server, sharing its router, until ctx is cancelled.
+func (h *HTTP) serveHTTP3(ctx context.Context, tlsConfig *tls.Config) {
+	server := &http3.Server{
+		Server: &http.Server{
+			Addr:      h.Listen,
+			Handler:   h.server.Handler,
+			TLSConfig: tlsConfig,
+		},
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	log.Infof("Starting HTTP/3 server on %s", h.Listen)
+	if err := server.ListenAndServe(); err != nil {
+		log.WithError(err).Error("HTTP/3 server failed")
+	}
+}
+
+// altSvcHandler wraps next with a middleware that advertises the HTTP/3
+// endpoint to clients via the Alt-Svc response header, when AltSvcHeader
+// and HTTP3 are both enabled.
+func (h *HTTP) altSvcHandler(next http.Handler) http.Handler {
+	if !h.AltSvcHeader || !h.HTTP3 {
+		return next
+	}
+	_, port, err := net.SplitHostPort(h.Listen)
+	if err != nil {
+		port = h.Listen
+	}
+	altSvc := `h3=":` + port + `"; ma=86400`
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Alt-Svc", altSvc)
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// applyClientCA wires ClientCAFile/ClientAuth (mutual TLS) into tlsConfig in
+// place, if ClientCAFile is set. It is shared by buildTLSConfig and the ACME
+// branch of ListenAndServe so that enabling ACME never silently drops an
+// operator's mTLS configuration.
+func (h *HTTP) applyClientCA(tlsConfig *tls.Config) error {
+	if h.ClientCAFile == "" {
+		return nil
+	}
+	pem, err := ioutil.ReadFile(h.ClientCAFile)
+	if err != nil {
+		return trace.Wrap(err, "error reading client CA file")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return trace.BadParameter("no valid certificates found in %v", h.ClientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	authType, err := h.clientAuthType()
+	if err != nil {
+		return trace.Wrap(err)
+	}
+	tlsConfig.ClientAuth = authType
+	return nil
+}
+
+// buildTLSConfig assembles a *tls.Config for the client-CA (mTLS) and SNI
+// multi-certificate options, or returns nil if neither is configured, in
+// which case ListenAndServe uses an empty tls.Config whose GetCertificate
+// serves the hot-reloadable default certificate.
+func (h *HTTP) buildTLSConfig() (*tls.Config, error) {
+	if h.ClientCAFile == "" && len(h.SNICerts) == 0 {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+
+	if err := h.applyClientCA(tlsConfig); err != nil {
+		return nil, err
+	}
+
+	if len(h.SNICerts) > 0 {
+		certMap, err := buildNamedCertificateMap(h.SNICerts)
+		if err != nil {
+			return nil, trace.Wrap(err)
+		}
+		tlsConfig.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if cert := certMap.getCertificate(hello.ServerName); cert != nil {
+				return cert, nil
+			}
+			return h.defaultCert.Load().(*tls.Certificate), nil
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// reloadDefaultCert loads CertFile/KeyFile and atomically swaps it in as the
+// certificate served by GetCertificate, recording the mtime used to detect
+// future changes.
+func (h *HTTP) reloadDefaultCert() error {
+	cert, err := tls.LoadX509KeyPair(h.CertFile, h.KeyFile)
+	if err != nil {
+		return trace.Wrap(err, "error loading TLS certificate")
+	}
+	h.defaultCert.Store(&cert)
+	h.certModTime = h.certFilesModTime()
+	return nil
+}
+
+// certFilesModTime returns the newer of CertFile's and KeyFile's mtimes.
+func (h *HTTP) certFilesModTime() time.Time {
+	var latest time.Time
+	for _, path := range []string{h.CertFile, h.KeyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// watchCertReload periodically checks CertFile/KeyFile for changes and
+// reloads the served certificate without dropping existing connections,
+// until ctx is cancelled.
+func (h *HTTP) watchCertReload(ctx context.Context) {
+	ticker := time.NewTicker(certReloadCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if h.certFilesModTime().After(h.certModTime) {
+				if err := h.reloadDefaultCert(); err != nil {
+					log.WithError(err).Error("Failed to reload TLS certificate")
+					continue
+				}
+				log.Info("Reloaded TLS certificate")
+			}
+		}
+	}
+}
+
+// acmeManager builds an autocert.Manager that caches certificates on disk
+// and restricts issuance to the configured hostnames.
+func (h *HTTP) acmeManager() *autocert.Manager {
+	manager := &autocert.Manager{
+		Prompt: autocert.AcceptTOS,
+		Email:  h.ACMEEmail,
+	}
+	if len(h.ACMEHostnames) > 0 {
+		manager.HostPolicy = autocert.HostWhitelist(h.ACMEHostnames...)
+	}
+	if h.ACMECacheDir != "" {
+		manager.Cache = autocert.DirCache(h.ACMECacheDir)
+	}
+	return manager
+}
+
 // Shutdown stops the server gracefully.
 func (h *HTTP) Shutdown(ctx context.Context) error {
+	if h.acmeChallengeServer != nil {
+		if err := h.acmeChallengeServer.Shutdown(ctx); err != nil {
+			log.WithError(err).Error("Failed to shut down ACME HTTP-01 challenge server")
+		}
+	}
 	return h.server.Shutdown(ctx)
 }
 
@@ -105,7 +521,7 @@ func (h *HTTP) ShutdownWithTimeout(ctx context.Context, duration time.Duration)
 
 // EnsureCert checks cert and key files consistency. It also generates a self-signed cert if it was not specified.
 func (h *HTTP) EnsureCert(defaultPath string) (err error) {
-	if h.Insecure {
+	if h.Insecure || h.ACMEEnabled {
 		return nil
 	}
 	// If files are specified by user then they should exist and possess right structure
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPConfigClientAuthType(t *testing.T) {
+	tests := []struct {
+		clientAuth string
+		want       tls.ClientAuthType
+		wantErr    bool
+	}{
+		{clientAuth: "require", want: tls.RequireAndVerifyClientCert},
+		{clientAuth: "verify-if-given", want: tls.VerifyClientCertIfGiven},
+		{clientAuth: "none", want: tls.NoClientCert},
+		{clientAuth: "", want: tls.NoClientCert},
+		{clientAuth: "bogus", wantErr: true},
+	}
+	for _, tt := range tests {
+		config := HTTPConfig{ClientAuth: tt.clientAuth}
+		got, err := config.clientAuthType()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("clientAuthType(%q) = %v, <nil>, want error", tt.clientAuth, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("clientAuthType(%q) returned unexpected error: %v", tt.clientAuth, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("clientAuthType(%q) = %v, want %v", tt.clientAuth, got, tt.want)
+		}
+	}
+}
+
+func TestNamedCertificateMapGetCertificate(t *testing.T) {
+	exact := &tls.Certificate{}
+	wildcard := &tls.Certificate{}
+	certMap := namedCertificateMap{
+		"foo.example.com": exact,
+		"*.example.com":   wildcard,
+	}
+
+	tests := []struct {
+		serverName string
+		want       *tls.Certificate
+	}{
+		{"foo.example.com", exact},
+		{"FOO.EXAMPLE.COM", exact},
+		{"bar.example.com", wildcard},
+		{"example.com", nil},
+		{"bar.other.com", nil},
+	}
+	for _, tt := range tests {
+		if got := certMap.getCertificate(tt.serverName); got != tt.want {
+			t.Errorf("getCertificate(%q) = %p, want %p", tt.serverName, got, tt.want)
+		}
+	}
+}
+
+func TestAltSvcHandler(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name         string
+		altSvcHeader bool
+		http3        bool
+		wantHeader   bool
+	}{
+		{"both enabled", true, true, true},
+		{"alt-svc disabled", false, true, false},
+		{"http3 disabled", true, false, false},
+		{"both disabled", false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &HTTP{HTTPConfig: HTTPConfig{
+				Listen:       "127.0.0.1:8443",
+				AltSvcHeader: tt.altSvcHeader,
+				HTTP3:        tt.http3,
+			}}
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			h.altSvcHandler(next).ServeHTTP(rec, req)
+
+			gotHeader := rec.Header().Get("Alt-Svc") != ""
+			if gotHeader != tt.wantHeader {
+				t.Errorf("Alt-Svc header present = %v, want %v", gotHeader, tt.wantHeader)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
+)
+
+type routeNameBoxContextKeyType struct{}
+
+var routeNameBoxContextKey routeNameBoxContextKeyType
+
+// routeNameBox is stashed in the request context by newHttpHandlerWrapper,
+// before the request reaches the router, and mutated in place by RouteName
+// once the matched handler is known. Middlewares that wrap the router from
+// the outside (e.g. MetricsMiddleware) run their post-request logic after
+// the router returns, using the same *http.Request they were called with;
+// since RouteName never replaces that request's context, only a shared,
+// in-place-mutable box makes the route name visible to them. httprouter
+// itself exposes no way to recover the matched route pattern from a
+// request, which is why routes that care about metrics must opt in by
+// registering through this wrapper.
+type routeNameBox struct {
+	name string
+}
+
+// unlabeledRoute is the metrics route label used for requests whose handler
+// wasn't registered with RouteName.
+const unlabeledRoute = "unlabeled"
+
+// withRouteNameBox seeds ctx with an empty routeNameBox for RouteName to
+// fill in later, once the router has matched a handler.
+func withRouteNameBox(ctx context.Context) context.Context {
+	return context.WithValue(ctx, routeNameBoxContextKey, &routeNameBox{})
+}
+
+// RouteName wraps an httprouter.Handle to tag it with a low-cardinality
+// route name (e.g. "webhooks", not the raw "/webhooks/:id/123" path), which
+// MetricsMiddleware then uses to label http_requests_total and
+// http_request_duration_seconds:
+//
+//	router.POST("/webhooks/:id", utils.RouteName("webhooks", handleWebhook))
+func RouteName(name string, handle httprouter.Handle) httprouter.Handle {
+	return func(rw http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if box, ok := r.Context().Value(routeNameBoxContextKey).(*routeNameBox); ok {
+			box.name = name
+		}
+		handle(rw, r, ps)
+	}
+}
+
+// routeNameFromContext returns the route name stashed by RouteName, or
+// unlabeledRoute if the matched handler wasn't registered through it.
+func routeNameFromContext(ctx context.Context) string {
+	if box, ok := ctx.Value(routeNameBoxContextKey).(*routeNameBox); ok && box.name != "" {
+		return box.name
+	}
+	return unlabeledRoute
+}
+
+// rateLimiterIdleTimeout is how long a per-IP limiter may sit unused before
+// it's evicted, so a long-running daemon doesn't accumulate one entry per
+// distinct client IP forever.
+const rateLimiterIdleTimeout = 10 * time.Minute
+
+// rateLimiterSweepInterval is how often idle limiters are swept out.
+const rateLimiterSweepInterval = time.Minute
+
+// Middleware wraps an http.Handler to add cross-cutting behaviour (logging,
+// metrics, rate limiting, auth, tracing, ...) around it.
+type Middleware func(http.Handler) http.Handler
+
+// Use registers additional middlewares to wrap the router with, in the
+// order given. They run outside any built-in middlewares enabled via
+// HTTPConfig (access log, metrics, rate limiting), so plugins can layer
+// their own auth or tracing without reimplementing the request wrapping
+// that ListenAndServe already does.
+func (h *HTTP) Use(mw ...Middleware) {
+	h.middlewares = append(h.middlewares, mw...)
+}
+
+// wrapHandler applies the built-in middlewares selected by HTTPConfig and
+// then any middlewares added via Use, innermost first. ctx bounds the
+// lifetime of any background goroutines the built-ins start (e.g. the rate
+// limiter's idle-entry sweeper).
+func (h *HTTP) wrapHandler(ctx context.Context, next http.Handler) http.Handler {
+	handler := next
+	if h.RateLimitRPS > 0 {
+		handler = RateLimitMiddleware(ctx, h.RateLimitRPS, h.RateLimitBurst)(handler)
+	}
+	if h.MetricsEnabled {
+		handler = MetricsMiddleware()(handler)
+	}
+	if h.AccessLog {
+		handler = AccessLogMiddleware()(handler)
+	}
+	for i := len(h.middlewares) - 1; i >= 0; i-- {
+		handler = h.middlewares[i](handler)
+	}
+	return handler
+}
+
+// statusRecorder captures the status code written by the handler so
+// middlewares can log/measure it after the fact.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// AccessLogMiddleware logs method, path, status, latency and remote address
+// for every request.
+func AccessLogMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			recorder := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+			start := time.Now()
+			next.ServeHTTP(recorder, r)
+			log.WithFields(log.Fields{
+				"method":      r.Method,
+				"path":        r.URL.Path,
+				"status":      recorder.status,
+				"latency":     time.Since(start),
+				"remote_addr": r.RemoteAddr,
+			}).Info("Handled HTTP request")
+		})
+	}
+}
+
+var (
+	httpRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Total number of HTTP requests processed, by route, method and status.",
+	}, []string{"route", "method", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "http_request_duration_seconds",
+		Help: "HTTP request latency in seconds, by route and method.",
+	}, []string{"route", "method"})
+)
+
+func init() {
+	prometheus.MustRegister(httpRequestsTotal, httpRequestDuration)
+}
+
+// MetricsMiddleware records http_requests_total and
+// http_request_duration_seconds for every request.
+//
+// Requests are labelled by the route name set via RouteName, never by the
+// raw request path: webhook receivers often have ID-bearing or templated
+// paths, and labelling by raw path would mean every distinct URL
+// (including 404 probes) becomes its own permanent time series.
+func MetricsMiddleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			recorder := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+			start := time.Now()
+			next.ServeHTTP(recorder, r)
+
+			duration := time.Since(start).Seconds()
+			route := routeNameFromContext(r.Context())
+			status := strconv.Itoa(recorder.status)
+			httpRequestsTotal.WithLabelValues(route, r.Method, status).Inc()
+			httpRequestDuration.WithLabelValues(route, r.Method).Observe(duration)
+		})
+	}
+}
+
+// rateLimiterEntry pairs a limiter with the last time it was used, so idle
+// entries can be swept out.
+type rateLimiterEntry struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// RateLimitMiddleware throttles requests per remote IP using a token-bucket
+// limiter, allowing rps requests per second with bursts up to burst.
+// Limiters that haven't been used for rateLimiterIdleTimeout are evicted by
+// a background sweep that runs until ctx is cancelled, so a long-running
+// daemon doesn't leak one entry per client IP it has ever seen.
+func RateLimitMiddleware(ctx context.Context, rps float64, burst int) Middleware {
+	var mu sync.Mutex
+	limiters := make(map[string]*rateLimiterEntry)
+
+	getLimiter := func(key string) *rate.Limiter {
+		mu.Lock()
+		defer mu.Unlock()
+		entry, ok := limiters[key]
+		if !ok {
+			entry = &rateLimiterEntry{limiter: rate.NewLimiter(rate.Limit(rps), burst)}
+			limiters[key] = entry
+		}
+		entry.lastSeen = time.Now()
+		return entry.limiter
+	}
+
+	go func() {
+		ticker := time.NewTicker(rateLimiterSweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				mu.Lock()
+				for key, entry := range limiters {
+					if time.Since(entry.lastSeen) > rateLimiterIdleTimeout {
+						delete(limiters, key)
+					}
+				}
+				mu.Unlock()
+			}
+		}
+	}()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			host := r.RemoteAddr
+			if i := strings.LastIndexByte(host, ':'); i != -1 {
+				host = host[:i]
+			}
+			if !getLimiter(host).Allow() {
+				http.Error(rw, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
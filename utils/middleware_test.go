@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	next := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+	handler := RateLimitMiddleware(ctx, 1, 2)(next)
+
+	newRequest := func(remoteAddr string) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		return req
+	}
+
+	// Burst of 2 from the same IP should pass...
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, newRequest("10.0.0.1:1234"))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d: got status %d, want %d", i, rec.Code, http.StatusOK)
+		}
+	}
+
+	// ...but the next one from the same IP should be throttled.
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest("10.0.0.1:1234"))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+
+	// A different IP gets its own bucket and isn't affected.
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest("10.0.0.2:1234"))
+	if rec.Code != http.StatusOK {
+		t.Errorf("got status %d, want %d", rec.Code, http.StatusOK)
+	}
+}